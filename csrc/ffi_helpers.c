@@ -10,6 +10,7 @@ enum {
     OMNI_FFI_DOUBLE = 2,
     OMNI_FFI_PTR    = 3,  // pointer (includes String, Ptr)
     OMNI_FFI_BOOL   = 4,
+    OMNI_FFI_STRING = 5,  // char*, converted to/from Omni strings by the caller
 };
 
 static ffi_type* omni_to_ffi_type(int t) {
@@ -19,6 +20,7 @@ static ffi_type* omni_to_ffi_type(int t) {
         case OMNI_FFI_DOUBLE: return &ffi_type_double;
         case OMNI_FFI_PTR:    return &ffi_type_pointer;
         case OMNI_FFI_BOOL:   return &ffi_type_sint64;
+        case OMNI_FFI_STRING: return &ffi_type_pointer;
         default:              return &ffi_type_pointer;
     }
 }
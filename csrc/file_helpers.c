@@ -0,0 +1,22 @@
+/*
+ * file_helpers.c — Directory listing for Omni Lisp
+ * Only needed because struct dirent's layout is platform-specific.
+ */
+
+#include <dirent.h>
+#include <stddef.h>
+
+void* omni_dir_open(const char* path) { return opendir(path); }
+
+/* Next entry name, skipping "." and ".."; NULL at the end. */
+const char* omni_dir_next(void* dir) {
+    struct dirent* e;
+    while ((e = readdir((DIR*)dir)) != NULL) {
+        const char* n = e->d_name;
+        if (n[0] == '.' && (n[1] == 0 || (n[1] == '.' && n[2] == 0))) continue;
+        return n;
+    }
+    return NULL;
+}
+
+void omni_dir_close(void* dir) { closedir((DIR*)dir); }
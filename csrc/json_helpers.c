@@ -93,3 +93,12 @@ char* omni_yyjson_mut_write(yyjson_mut_doc* doc, size_t* len) {
 char* omni_yyjson_mut_write_pretty(yyjson_mut_doc* doc, size_t* len) {
     return yyjson_mut_write(doc, YYJSON_WRITE_PRETTY, len);
 }
+
+/* Pretty-print with indent spaces per level (2 or 4); 0 writes compact. */
+char* omni_yyjson_mut_write_indent(yyjson_mut_doc* doc, int indent, size_t* len) {
+    if (indent == 0) return yyjson_mut_write(doc, 0, len);
+#if YYJSON_VERSION_HEX >= 0x000700
+    if (indent == 2) return yyjson_mut_write(doc, YYJSON_WRITE_PRETTY_TWO_SPACES, len);
+#endif
+    return yyjson_mut_write(doc, YYJSON_WRITE_PRETTY, len);
+}
@@ -0,0 +1,99 @@
+/*
+ * process_helpers.c — Subprocess execution for Omni Lisp
+ * Runs a command without a shell and captures stdout and stderr separately,
+ * which popen cannot do.
+ */
+
+#include <errno.h>
+#include <poll.h>
+#include <stdlib.h>
+#include <string.h>
+#include <sys/wait.h>
+#include <unistd.h>
+
+typedef struct {
+    char*  data;
+    size_t len;
+    size_t cap;
+} OmniBuf;
+
+static int buf_read(OmniBuf* b, int fd) {
+    if (b->cap - b->len < 4096) {
+        size_t cap = b->cap ? b->cap * 2 : 8192;
+        char* data = realloc(b->data, cap);
+        if (data == NULL) return -1;
+        b->data = data;
+        b->cap = cap;
+    }
+    for (;;) {
+        ssize_t n = read(fd, b->data + b->len, b->cap - b->len);
+        if (n < 0 && errno == EINTR) continue;
+        if (n > 0) b->len += (size_t)n;
+        return (int)n;
+    }
+}
+
+/*
+ * Run argv[0] (searched in PATH) with argv as its arguments. On success
+ * returns the exit code (128 + signal if killed) and hands back malloc'd
+ * output buffers, which the caller frees. Returns -1 if the command could
+ * not be started; exit code 127 means it was not found.
+ */
+int omni_exec(char** argv, char** out, size_t* out_len, char** err, size_t* err_len) {
+    int out_pipe[2], err_pipe[2];
+    *out = NULL; *out_len = 0;
+    *err = NULL; *err_len = 0;
+    if (pipe(out_pipe) < 0) return -1;
+    if (pipe(err_pipe) < 0) {
+        close(out_pipe[0]); close(out_pipe[1]);
+        return -1;
+    }
+
+    pid_t pid = fork();
+    if (pid < 0) {
+        close(out_pipe[0]); close(out_pipe[1]);
+        close(err_pipe[0]); close(err_pipe[1]);
+        return -1;
+    }
+    if (pid == 0) {
+        dup2(out_pipe[1], STDOUT_FILENO);
+        dup2(err_pipe[1], STDERR_FILENO);
+        close(out_pipe[0]); close(out_pipe[1]);
+        close(err_pipe[0]); close(err_pipe[1]);
+        execvp(argv[0], argv);
+        _exit(127);
+    }
+    close(out_pipe[1]);
+    close(err_pipe[1]);
+
+    OmniBuf ob = {0}, eb = {0};
+    struct pollfd fds[2] = {
+        { .fd = out_pipe[0], .events = POLLIN },
+        { .fd = err_pipe[0], .events = POLLIN },
+    };
+    int open_fds = 2;
+    while (open_fds > 0) {
+        if (poll(fds, 2, -1) < 0) {
+            if (errno == EINTR) continue;
+            break;
+        }
+        for (int i = 0; i < 2; i++) {
+            if (fds[i].fd < 0 || fds[i].revents == 0) continue;
+            if (buf_read(i == 0 ? &ob : &eb, fds[i].fd) <= 0) {
+                close(fds[i].fd);
+                fds[i].fd = -1;
+                open_fds--;
+            }
+        }
+    }
+    for (int i = 0; i < 2; i++) if (fds[i].fd >= 0) close(fds[i].fd);
+
+    int status = 0;
+    while (waitpid(pid, &status, 0) < 0 && errno == EINTR) {}
+
+    *out = ob.data; *out_len = ob.len;
+    *err = eb.data; *err_len = eb.len;
+    if (WIFEXITED(status)) return WEXITSTATUS(status);
+    if (WIFSIGNALED(status)) return 128 + WTERMSIG(status);
+    return -1;
+}
@@ -16,6 +16,8 @@
 (define [effect] (io/tcp-read (^Any handle)))
 (define [effect] (io/tcp-write (^Any args)))
 (define [effect] (io/tcp-close (^Any handle)))
+(define [effect] (io/tcp-listen (^Any args)))
+(define [effect] (io/tcp-accept (^Any handle)))
 (define [effect] (io/dns-resolve (^String host)))
 (define [effect] (io/async-sleep (^Int ms)))
 (define [effect] (io/tls-connect (^Any args)))
@@ -129,6 +131,25 @@
 ;; assert!: check condition, raise if false
 (define (assert! condition msg) (if condition true (signal raise msg)))
 
+;; define-contract: (define-contract (f x ..) :pre test :post test body ..)
+;; checks test over the parameters before the body and test over them and
+;; `result` after it, in checked mode (omni --checked or (checked-mode true))
+(define [macro] define-contract ([[name .. params] ':pre pre ':post post .. body] (define name (lambda (.. params) (__contract-call 'name (lambda () pre) 'pre (lambda (result) post) 'post (lambda () (begin .. body)))))) ([[name .. params] ':pre pre .. body] (define name (lambda (.. params) (__contract-call 'name (lambda () pre) 'pre nil nil (lambda () (begin .. body)))))) ([[name .. params] ':post post .. body] (define name (lambda (.. params) (__contract-call 'name nil nil (lambda (result) post) 'post (lambda () (begin .. body)))))))
+
+;; Tests, run with omni --test: is and is= record a failure and carry on;
+;; throws? is true if its body raises
+(define [macro] deftest ([name .. body] (__test-register 'name (lambda () (begin .. body)))))
+(define [macro] is ([e] (__test-is 'e e)))
+(define [macro] is= ([a b] (__test-is= '(is= a b) a b)))
+(define [macro] throws? ([e .. body] (try (lambda (_) (begin e .. body nil)) (lambda (msg) true))))
+
+;; bench: (bench "name" :iterations n expr) or (bench "name" expr) to pick n
+(define [macro] bench ([name ':iterations n e] (__bench name n (lambda () e))) ([name e] (__bench name 0 (lambda () e))))
+
+;; define-memo: (define-memo (f x ..) body ..) defines f, then rebinds it to
+;; (memoize f) so its recursive calls hit the cache too
+(define [macro] define-memo ([[name .. params] .. body] (begin (define name (lambda (.. params) (begin .. body))) (define name (memoize name)))))
+
 ;; =========================================================================
 ;; Association List Helpers
 ;; =========================================================================
@@ -176,6 +197,15 @@
 (define (delay thunk) (let (result nil forced nil) (lambda () (if forced result (begin (set! result (thunk nil)) (set! forced true) result)))))
 (define (force p) (p))
 
+;; =========================================================================
+;; Queues
+;; =========================================================================
+;; Queue names for the persistent deque primitives: enqueue adds at the back,
+;; dequeue drops the front and peek reads it.
+(define (enqueue q x) (push-back q x))
+(define (dequeue q) (pop-front q))
+(define (peek q) (peek-front q))
+
 ;; =========================================================================
 ;; Iterators: Lazy Sequences
 ;; =========================================================================
@@ -225,6 +255,8 @@
 (define tcp-read (lambda (handle) (signal io/tcp-read handle)))
 (define tcp-write (lambda (handle data) (signal io/tcp-write (cons handle data))))
 (define tcp-close (lambda (handle) (signal io/tcp-close handle)))
+(define tcp-listen (lambda (host port) (signal io/tcp-listen (cons host port))))
+(define tcp-accept (lambda (listener) (signal io/tcp-accept listener)))
 (define dns-resolve (lambda (host) (signal io/dns-resolve host)))
 (define async-sleep (lambda (ms) (signal io/async-sleep ms)))
 (define tls-connect (lambda (tcp-handle hostname) (signal io/tls-connect (cons tcp-handle hostname))))
@@ -235,6 +267,104 @@
 (define [effect] (io/http-request (^Any args)))
 (define http-get (lambda (url) (signal io/http-get url)))
 
+;; spawn-chan: runs thunk in a fiber and returns a channel that receives its
+;; result, or is closed if thunk fails. The -chan variants below let a fiber
+;; wait on network I/O with chan-recv or select while others keep running.
+(define (spawn-chan thunk) (let (ch (make-chan 1)) (begin (spawn (lambda () (try (lambda (_) (chan-send ch (thunk))) (lambda (msg) (chan-close! ch))))) ch)))
+(define (tcp-accept-chan listener) (spawn-chan (lambda () (tcp-accept listener))))
+(define (tcp-read-chan handle) (spawn-chan (lambda () (tcp-read handle))))
+(define (http-get-chan url) (spawn-chan (lambda () (http-get url))))
+
+;; =========================================================================
+;; Call Tracing
+;; =========================================================================
+;; (trace 'f) rebinds the global f to a wrapper that prints each call and its
+;; result, indented by nesting depth. (untrace 'f) restores the original.
+(define *trace-depth* 0)
+(define *traced* (dict))
+(define (trace-indent n) (when (> n 0) (display "| ") (trace-indent (- n 1))))
+(define (trace-call name f args) (begin (trace-indent *trace-depth*) (display "> ") (println (cons name args)) (set! *trace-depth* (+ *trace-depth* 1)) (let (result (apply f args)) (begin (set! *trace-depth* (- *trace-depth* 1)) (trace-indent *trace-depth*) (display "< ") (println result) result))))
+(define (trace name) (if (has? *traced* name) name (let (f (eval name)) (begin (dict-set! *traced* name f) (eval (list 'define name (list 'quote (lambda (.. args) (trace-call name f args))))) name))))
+(define (untrace name) (if (has? *traced* name) (begin (eval (list 'define name (list 'quote (ref *traced* name)))) (remove! *traced* name) name) nil))
+
+;; =========================================================================
+;; Timeouts and Locks
+;; =========================================================================
+;; with-timeout: (with-timeout ms body ..) runs body as a fiber and raises
+;; "with-timeout: timed out" if it has not finished within ms milliseconds.
+(define [macro] with-timeout ([ms .. body] (call-with-timeout ms (lambda () (begin .. body)))))
+
+;; with-lock: (with-lock m body ..) runs body holding mutex m, then unlocks it.
+(define [macro] with-lock ([m .. body] (call-with-lock m (lambda () (begin .. body)))))
+
+;; with-open: (with-open (p (open path)) body ..) runs body with p bound to
+;; the port, then closes it, also when body fails.
+(define [macro] with-open ([[name port] .. body] (call-with-port port (lambda (name) (begin .. body)))))
+
+;; parallel: (parallel e1 e2 ..) evaluates each expression in its own fiber,
+;; waits for all and returns their values as a list, or raises the first error.
+(define [macro] parallel-thunks ([] nil) ([e .. rest] (cons (lambda () e) (parallel-thunks .. rest))))
+(define [macro] parallel ([] nil) ([e .. rest] (call-parallel (parallel-thunks e .. rest))))
+
+;; =========================================================================
+;; Dynamic Variables
+;; =========================================================================
+;; (defdynamic *name* [init]) declares a dynamic variable, nil unless init is
+;; given. (parameterize ((*name* v) ..) body ..) rebinds them for the dynamic
+;; extent of body, including the fibers and coroutines it creates.
+(define [macro] defdynamic ([name] (__defdynamic 'name nil)) ([name init] (__defdynamic 'name init)))
+(define [macro] parameterize-names ([] nil) ([[name v] .. rest] (cons 'name (parameterize-names .. rest))))
+(define [macro] parameterize-values ([] nil) ([[name v] .. rest] (cons v (parameterize-values .. rest))))
+(define [macro] parameterize ([bindings .. body] (__parameterize (parameterize-names .. bindings) (parameterize-values .. bindings) (lambda () (begin .. body)))))
+
+;; =========================================================================
+;; REPL Modules
+;; =========================================================================
+;; (in-module Name) makes later REPL input evaluate in module Name, created
+;; if needed, as :module Name does; (in-module) returns to the top level.
+(define [macro] in-module ([] (__in-module nil)) ([name] (__in-module 'name)))
+
+;; =========================================================================
+;; Step Budgets
+;; =========================================================================
+;; (with-fuel n body ..) evaluates body, raising "with-fuel: step budget of n
+;; exhausted" if it takes more than n steps (function bodies entered plus
+;; tail calls followed). (fuel-left) reports the steps remaining.
+(define [macro] with-fuel ([n .. body] (__with-fuel n (lambda () (begin .. body)))))
+
+;; =========================================================================
+;; Parallel Map and Reduce
+;; =========================================================================
+;; (pmap f lst [chunk]) and (preduce f init lst [chunk]) split lst into chunks
+;; of `chunk` elements (default *pmap-chunk-size*) and run each chunk in its
+;; own fiber. A list of at most one chunk is processed serially. preduce
+;; folds every chunk from init and then folds the chunk results, so f must be
+;; associative with init as its identity. Fibers share one OS thread, so this
+;; pays off when f waits (sleep, channels, I/O) rather than for pure compute.
+(define *pmap-chunk-size* 64)
+(define (pmap-chunk-size opts) (let (n (if (null? opts) *pmap-chunk-size* (car opts))) (if (and (int? n) (> n 0)) n (error "pmap: chunk size must be a positive integer"))))
+(define (pmap-chunks n lst) (let loop (xs lst acc nil) (if (null? xs) (reverse acc) (loop (drop n xs) (cons (take n xs) acc)))))
+(define (pmap f lst .. opts) (let (n (pmap-chunk-size opts)) (if (<= (length lst) n) (map f lst) (foldr append nil (call-parallel (map (lambda (c) (lambda () (map f c))) (pmap-chunks n lst)))))))
+(define (preduce f init lst .. opts) (let (n (pmap-chunk-size opts)) (if (<= (length lst) n) (foldl f init lst) (foldl f init (call-parallel (map (lambda (c) (lambda () (foldl f init c))) (pmap-chunks n lst)))))))
+
+;; =========================================================================
+;; Channel Iteration
+;; =========================================================================
+;; chan-iterator: lazy iterator over received values, ending when the channel
+;; is closed and drained. (collect (chan-iterator ch)) gathers them all.
+(define (chan-iterator ch) (make-iterator (lambda () (let (v (chan-recv ch)) (if (= v chan-closed) nil (cons v (chan-iterator ch)))))))
+
+;; =========================================================================
+;; Channel Select
+;; =========================================================================
+;; (select ((recv ch v) body ..) ((send ch x) body ..) (:default body ..))
+;; runs the body of the first channel operation that can proceed, waiting
+;; for one unless there is a :default clause. select-ops builds the operation
+;; list for chan-select; select-dispatch runs the clause at the index it returns.
+(define [macro] select-ops ([] nil) ([[['recv ch v] .. body] .. rest] (cons (list 'recv ch) (select-ops .. rest))) ([[['send ch x] .. body] .. rest] (cons (list 'send ch x) (select-ops .. rest))) ([[':default .. body] .. rest] (cons (list 'default) (select-ops .. rest))))
+(define [macro] select-dispatch ([r n] nil) ([r n [['recv ch v] .. body] .. rest] (if (= (car r) n) (let (v (cdr r)) (begin .. body)) (select-dispatch r (+ n 1) .. rest))) ([r n [['send ch x] .. body] .. rest] (if (= (car r) n) (begin .. body) (select-dispatch r (+ n 1) .. rest))) ([r n [':default .. body] .. rest] (if (= (car r) n) (begin .. body) (select-dispatch r (+ n 1) .. rest))))
+(define [macro] select ([clause .. rest] (let (r# (chan-select (select-ops clause .. rest))) (select-dispatch r# 0 clause .. rest))))
+
 ;; =========================================================================
 ;; Handler Composition
 ;; =========================================================================